@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path"
@@ -9,6 +10,8 @@ import (
 	"sync"
 	"time"
 
+	"dsiem/internal/dsiem/pkg/xcorrelator"
+
 	"github.com/teris-io/shortid"
 )
 
@@ -27,6 +30,11 @@ type backLog struct {
 	Directive    directive `json:"directive"`
 	SrcIPs       []string  `json:"src_ips"`
 	DstIPs       []string  `json:"dst_ips"`
+
+	// Intel holds every threat intel match found so far for this backlog's
+	// events, across all artifact types (IP, hash, URL, domain), so alarms
+	// get enriched with the full set of matched indicators.
+	Intel []xcorrelator.IntelResult `json:"intel,omitempty"`
 }
 type siemAlarmEvents struct {
 	ID    string `json:"alarm_id"`
@@ -42,21 +50,39 @@ type removalChannelMsg struct {
 	connID uint64
 }
 
+// BackLogStore abstracts where backlog state lives and who is allowed to run
+// the timeout sweep. localBackLogStore keeps everything in this process's
+// memory, which is all a single-node deployment needs. etcdBackLogStore
+// (backlog_store_etcd.go) instead persists each backlog in etcd and uses
+// leader election so that only one node in a cluster sweeps for timeouts.
+type BackLogStore interface {
+	initBackLog() error
+	createNewBackLog(d *directive, e *normalizedEvent)
+	removeBackLog(m removalChannelMsg)
+	processMatchedEvent(b *backLog, e *normalizedEvent, idx int, intel []xcorrelator.IntelResult)
+}
+
 var backLogRemovalChannel chan removalChannelMsg
 var bLogs backLogs
 var sid *shortid.Shortid
 var ticker *time.Ticker
 
+// blStore is the active BackLogStore. It defaults to an in-memory store and
+// is only swapped for the etcd-backed one when etcd endpoints are configured,
+// so single-node deployments keep today's behavior unchanged.
+var blStore BackLogStore = &localBackLogStore{}
+
 func initShortID() (err error) {
 	sid, err = shortid.New(1, shortid.DefaultABC, 2342)
 	return
 }
 
+// initBackLog wires up the configured BackLogStore. Call useEtcdBackLogStore
+// before this to opt into the distributed store.
 func initBackLog() (err error) {
 	if err = initShortID(); err != nil {
 		return
 	}
-	startBackLogTicker()
 	backLogRemovalChannel = make(chan removalChannelMsg)
 	go func() {
 		for {
@@ -65,7 +91,28 @@ func initBackLog() (err error) {
 			go removeBackLog(msg)
 		}
 	}()
-	return
+	return blStore.initBackLog()
+}
+
+type localBackLogStore struct{}
+
+func (s *localBackLogStore) initBackLog() error {
+	startBackLogTicker()
+	return nil
+}
+
+func (s *localBackLogStore) createNewBackLog(d *directive, e *normalizedEvent) {
+	doCreateNewBackLog(d, e)
+}
+
+func (s *localBackLogStore) removeBackLog(m removalChannelMsg) {
+	doRemoveBackLog(m)
+}
+
+func (s *localBackLogStore) processMatchedEvent(b *backLog, e *normalizedEvent, idx int, intel []xcorrelator.IntelResult) {
+	ctx, release := blLockMgr.acquire(b.ID, e.ConnID)
+	defer release()
+	b.processMatchedEvent(ctx, e, idx, intel)
 }
 
 // this checks for timed-out backlog and discard it
@@ -74,28 +121,39 @@ func startBackLogTicker() {
 	go func() {
 		for {
 			<-ticker.C
-			logDebug("Ticker started, # of backlogs to check: "+strconv.Itoa(len(bLogs.BackLogs)), 0)
-			now := time.Now().Unix()
-			bLogs.mu.RLock()
-			for i := range bLogs.BackLogs {
-				cs := bLogs.BackLogs[i].CurrentStage
-				idx := cs - 1
-				start := bLogs.BackLogs[i].Directive.Rules[idx].StartTime
-				timeout := bLogs.BackLogs[i].Directive.Rules[idx].Timeout
-				maxTime := start + timeout
-				if maxTime > now {
-					continue
-				}
-				logInfo("directive "+strconv.Itoa(bLogs.BackLogs[i].Directive.ID)+" backlog "+bLogs.BackLogs[i].ID+" expired.", 0)
-				bLogs.BackLogs[i].setStatus("timeout", 0)
-				bLogs.BackLogs[i].delete(0)
-			}
-			bLogs.mu.RUnlock()
+			sweepExpiredBackLogs()
 		}
 	}()
 }
 
+// sweepExpiredBackLogs walks the local cache and deletes any backlog whose
+// current stage has passed its timeout. Stores only call this from a single
+// leader at a time, local or elected, so a backlog is never swept twice.
+func sweepExpiredBackLogs() {
+	logDebug("Ticker started, # of backlogs to check: "+strconv.Itoa(len(bLogs.BackLogs)), 0)
+	now := time.Now().Unix()
+	bLogs.mu.RLock()
+	for i := range bLogs.BackLogs {
+		cs := bLogs.BackLogs[i].CurrentStage
+		idx := cs - 1
+		start := bLogs.BackLogs[i].Directive.Rules[idx].StartTime
+		timeout := bLogs.BackLogs[i].Directive.Rules[idx].Timeout
+		maxTime := start + timeout
+		if maxTime > now {
+			continue
+		}
+		logInfo("directive "+strconv.Itoa(bLogs.BackLogs[i].Directive.ID)+" backlog "+bLogs.BackLogs[i].ID+" expired.", 0)
+		bLogs.BackLogs[i].setStatus("timeout", 0)
+		bLogs.BackLogs[i].delete(0)
+	}
+	bLogs.mu.RUnlock()
+}
+
 func removeBackLog(m removalChannelMsg) {
+	blStore.removeBackLog(m)
+}
+
+func doRemoveBackLog(m removalChannelMsg) {
 	logDebug("Trying to obtain write lock to remove backlog "+m.ID, m.connID)
 	bLogs.mu.Lock()
 	defer bLogs.mu.Unlock()
@@ -119,6 +177,11 @@ func removeBackLog(m removalChannelMsg) {
 }
 
 func backlogManager(e *normalizedEvent, d *directive) {
+	// Resolve e's intel matches before touching bLogs.mu or a backlog's lock:
+	// this is the only part of processMatchedEvent that does network I/O, and
+	// the result is the same regardless of which backlog(s) e ends up matching.
+	intel := lookupEventIntelMatches(e)
+
 	found := false
 	bLogs.mu.RLock()
 	for i := range bLogs.BackLogs {
@@ -136,7 +199,7 @@ func backlogManager(e *normalizedEvent, d *directive) {
 		}
 		logDebug("Directive "+strconv.Itoa(d.ID)+" backlog "+bLogs.BackLogs[i].ID+" matched. Not creating new backlog.", e.ConnID)
 		found = true
-		bLogs.BackLogs[i].processMatchedEvent(e, idx)
+		blStore.processMatchedEvent(&bLogs.BackLogs[i], e, idx, intel)
 	}
 	bLogs.mu.RUnlock()
 
@@ -147,6 +210,15 @@ func backlogManager(e *normalizedEvent, d *directive) {
 }
 
 func createNewBackLog(d *directive, e *normalizedEvent) {
+	blStore.createNewBackLog(d, e)
+}
+
+// doCreateNewBackLog builds and registers a new backlog for e, returning the
+// backlog it created so a store like etcdBackLogStore can persist exactly
+// that backlog instead of re-deriving it from bLogs.BackLogs afterwards,
+// which would race with any other goroutine creating or removing a backlog
+// in between.
+func doCreateNewBackLog(d *directive, e *normalizedEvent) backLog {
 	// create new backlog here, passing the event as the 1st event for the backlog
 	bid, _ := sid.Generate()
 	logInfo("Directive "+strconv.Itoa(d.ID)+" created new backlog "+bid, e.ConnID)
@@ -160,12 +232,21 @@ func createNewBackLog(d *directive, e *normalizedEvent) {
 
 	b.CurrentStage = 1
 	b.HighestStage = len(d.Rules)
-	b.processMatchedEvent(e, 0)
+
+	// resolved before the backlog lock so the HTTP round-trips it can take
+	// never hold up another goroutine's create/remove/process on this or any
+	// other backlog
+	intel := lookupEventIntelMatches(e)
+
+	ctx, release := blLockMgr.acquire(bid, e.ConnID)
+	b.processMatchedEvent(ctx, e, 0, intel)
+	release()
 	logDebug("Trying to obtain write lock to create backlog "+bid, e.ConnID)
 	bLogs.mu.Lock()
 	bLogs.BackLogs = append(bLogs.BackLogs, b)
 	bLogs.mu.Unlock()
 	logDebug("Lock obtained/released for backlog "+bid+" creation.", e.ConnID)
+	return b
 }
 
 func copyDirective(dst *directive, src *directive, e *normalizedEvent) {
@@ -284,9 +365,9 @@ func (b *backLog) ensureStatusAndStartTime(idx int, connID uint64) {
 	}
 }
 
-func (b *backLog) processMatchedEvent(e *normalizedEvent, idx int) {
+func (b *backLog) processMatchedEvent(ctx context.Context, e *normalizedEvent, idx int, intel []xcorrelator.IntelResult) {
 
-	b.appendandWriteEvent(e, idx)
+	b.appendandWriteEvent(ctx, e, idx, intel)
 
 	// exit early if the newly added event hasnt caused events_count == occurrence
 	// for the current stage
@@ -316,16 +397,85 @@ func (b *backLog) processMatchedEvent(e *normalizedEvent, idx int) {
 	}
 }
 
-func (b *backLog) appendandWriteEvent(e *normalizedEvent, idx int) {
+func (b *backLog) appendandWriteEvent(ctx context.Context, e *normalizedEvent, idx int, intel []xcorrelator.IntelResult) {
 	b.Directive.Rules[idx].Events = append(b.Directive.Rules[idx].Events, e.EventID)
 	b.SrcIPs = appendStringUniq(b.SrcIPs, e.SrcIP)
 	b.DstIPs = appendStringUniq(b.DstIPs, e.DstIP)
 
-	if err := b.updateElasticsearch(e); err != nil {
+	if b.mergeIntelMatches(intel) {
+		upsertAlarmFromBackLog(b, e.ConnID)
+	}
+
+	if err := b.updateElasticsearch(ctx, e); err != nil {
 		logWarn("Backlog "+b.ID+" failed to update Elasticsearch! "+err.Error(), e.ConnID)
 	}
 }
 
+// artifactLookup pairs an IOC value pulled off a normalizedEvent with the
+// xcorrelator.CheckIntel artifact type it should be looked up as.
+type artifactLookup struct {
+	artifactType string
+	value        string
+}
+
+// lookupEventIntelMatches looks up whichever IOC-bearing fields are
+// populated on e (file hash, URL, domain; IPs are matched elsewhere as part
+// of directive rule matching) against the configured intel sources. It does
+// real HTTP round-trips, so callers must call it before acquiring bLogs.mu or
+// a backlog's lock, not while holding either: the result doesn't depend on
+// which backlog(s) e ends up matching, only on e itself.
+func lookupEventIntelMatches(e *normalizedEvent) (results []xcorrelator.IntelResult) {
+	if !xcorrelator.IntelEnabled {
+		return nil
+	}
+
+	lookups := []artifactLookup{
+		{xcorrelator.ArtifactTypeHash, e.Hash},
+		{xcorrelator.ArtifactTypeURL, e.URL},
+		{xcorrelator.ArtifactTypeDomain, e.Domain},
+	}
+	for _, l := range lookups {
+		if l.value == "" {
+			continue
+		}
+		found, r := xcorrelator.CheckIntel(l.artifactType, l.value, e.ConnID)
+		if !found {
+			continue
+		}
+		results = append(results, r...)
+	}
+	return results
+}
+
+// mergeIntelMatches appends whichever of intel aren't already recorded on b
+// to b.Intel. It's pure in-memory bookkeeping - the network lookups that
+// produced intel already happened in lookupEventIntelMatches - so it's safe
+// to call while holding a backlog's lock. It reports whether anything new
+// was appended, so the caller knows whether the alarm needs to be
+// re-upserted.
+func (b *backLog) mergeIntelMatches(intel []xcorrelator.IntelResult) (changed bool) {
+	for _, ir := range intel {
+		if b.hasIntelResult(ir) {
+			continue
+		}
+		b.Intel = append(b.Intel, ir)
+		changed = true
+	}
+	return changed
+}
+
+// hasIntelResult reports whether ir is already recorded on b, so repeat
+// events carrying the same IOC (e.g. a beacon seen across several
+// occurrences of a rule) don't pile up duplicate entries.
+func (b *backLog) hasIntelResult(ir xcorrelator.IntelResult) bool {
+	for _, existing := range b.Intel {
+		if existing.Provider == ir.Provider && existing.Term == ir.Term {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *backLog) isLastStage() bool {
 	return b.CurrentStage == b.HighestStage
 }
@@ -382,7 +532,16 @@ func (b *backLog) delete(connID uint64) {
 	alarmRemovalChannel <- m
 }
 
-func (b *backLog) updateElasticsearch(e *normalizedEvent) error {
+// updateElasticsearch appends this event to siem_alarm_events.json. ctx is
+// the lock-holder's context from backLogLockManager.acquire: if the caller's
+// backlog lock has expired without being refreshed (e.g. the holder is stuck
+// or dead), ctx is canceled and this aborts before writing a half-finished
+// entry under a lock that another goroutine's acquire may already have taken
+// over.
+func (b *backLog) updateElasticsearch(ctx context.Context, e *normalizedEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	logDebug("directive "+strconv.Itoa(b.Directive.ID)+" backlog "+b.ID+" updating Elasticsearch.", e.ConnID)
 	filename := path.Join(progDir, logsDir, aEventsLogs)
 	b.StatusTime = time.Now().Unix()
@@ -396,6 +555,9 @@ func (b *backLog) updateElasticsearch(e *normalizedEvent) error {
 	v := siemAlarmEvents{b.ID, b.CurrentStage, e.EventID}
 	vJSON, _ := json.Marshal(v)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	_, err = f.WriteString(string(vJSON) + "\n")
 	return err
 }
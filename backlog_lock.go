@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	backLogLockTTL     = 30 * time.Second
+	backLogLockRefresh = 10 * time.Second
+)
+
+// backLogLock is one outstanding lock on a backLog.ID: who holds it, when it
+// expires without a refresh, and the cancel func for the context handed to
+// the holder.
+type backLogLock struct {
+	holder  uint64
+	expires time.Time
+	cancel  context.CancelFunc
+}
+
+// backLogLockManager hands out one lock per backlog ID so that mutating a
+// backlog's contents in processMatchedEvent no longer serializes behind the
+// single bLogs.mu.Lock() held by every other backlog's processMatchedEvent.
+// createNewBackLog and removeBackLog still take bLogs.mu.Lock() for the
+// duration of their append/swap-delete on bLogs.BackLogs - that's structural
+// mutation of the shared slice itself, not per-backlog content mutation, so
+// per-ID locking doesn't apply to them. A lock expires backLogLockTTL after
+// its last refresh, so a holder that dies mid-processMatchedEvent can't
+// wedge a backlog forever; reapExpired clears it out and cancels its
+// context.
+type backLogLockManager struct {
+	mu    sync.Mutex
+	locks map[string]*backLogLock
+}
+
+var blLockMgr = newBackLogLockManager()
+
+func newBackLogLockManager() *backLogLockManager {
+	m := &backLogLockManager{locks: make(map[string]*backLogLock)}
+	go m.reapExpired()
+	return m
+}
+
+// acquire blocks until id's lock is free, then returns a context that is
+// canceled the moment the background refresh stops renewing the lock
+// (release was called, or the refresh loop found the lock already reaped)
+// plus a release func the caller must call exactly once when done. Callers
+// like updateElasticsearch should check ctx.Err() before doing externally
+// visible work, so they abort instead of finishing under an expired lock.
+func (m *backLogLockManager) acquire(id string, connID uint64) (context.Context, func()) {
+	for {
+		m.mu.Lock()
+		l, busy := m.locks[id]
+		if !busy || time.Now().After(l.expires) {
+			ctx, cancel := context.WithCancel(context.Background())
+			l = &backLogLock{holder: connID, expires: time.Now().Add(backLogLockTTL), cancel: cancel}
+			m.locks[id] = l
+			m.mu.Unlock()
+			stop := make(chan struct{})
+			go m.refresh(id, l, stop)
+			return ctx, func() {
+				close(stop)
+				m.release(id, l)
+			}
+		}
+		m.mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// refresh renews l's TTL every backLogLockRefresh until stop is closed by
+// release, or until it notices the lock was reaped out from under it, in
+// which case it cancels the holder's context.
+func (m *backLogLockManager) refresh(id string, l *backLogLock, stop chan struct{}) {
+	t := time.NewTicker(backLogLockRefresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			m.mu.Lock()
+			cur, ok := m.locks[id]
+			if !ok || cur != l {
+				m.mu.Unlock()
+				l.cancel()
+				return
+			}
+			l.expires = time.Now().Add(backLogLockTTL)
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *backLogLockManager) release(id string, l *backLogLock) {
+	m.mu.Lock()
+	if cur, ok := m.locks[id]; ok && cur == l {
+		delete(m.locks, id)
+	}
+	m.mu.Unlock()
+	l.cancel()
+}
+
+// reapExpired periodically clears out locks whose holder stopped refreshing
+// them, the lock-manager analogue of startBackLogTicker's sweep for timed-out
+// backlogs. acquire already takes over an expired lock on its own; this
+// sweep clears expired locks whose ID nobody acquires again, and cancels
+// their holders' contexts.
+func (m *backLogLockManager) reapExpired() {
+	ticker := time.NewTicker(backLogLockRefresh)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.reapOnce()
+	}
+}
+
+// reapOnce runs a single sweep of reapExpired's logic, split out so tests
+// can exercise it without waiting on the real backLogLockRefresh ticker.
+func (m *backLogLockManager) reapOnce() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, l := range m.locks {
+		if now.After(l.expires) {
+			logWarn("backlog lock for "+id+" expired without refresh, reaping it", l.holder)
+			delete(m.locks, id)
+			l.cancel()
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackLogLockManagerAcquireRelease(t *testing.T) {
+	m := &backLogLockManager{locks: make(map[string]*backLogLock)}
+
+	ctx, release := m.acquire("b1", 1)
+	if ctx.Err() != nil {
+		t.Fatalf("fresh lock's context should not be canceled, got %v", ctx.Err())
+	}
+	release()
+	if ctx.Err() == nil {
+		t.Fatalf("context should be canceled once the lock is released")
+	}
+}
+
+func TestBackLogLockManagerSerializesSameID(t *testing.T) {
+	m := &backLogLockManager{locks: make(map[string]*backLogLock)}
+
+	_, release1 := m.acquire("b1", 1)
+
+	acquired := make(chan struct{})
+	go func() {
+		_, release2 := m.acquire("b1", 2)
+		close(acquired)
+		release2()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("second acquire should block while the first holder still has the lock")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	release1()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("second acquire should proceed once the first holder releases")
+	}
+}
+
+func TestBackLogLockManagerAcquireReclaimsExpiredLock(t *testing.T) {
+	m := &backLogLockManager{locks: make(map[string]*backLogLock)}
+	m.locks["b1"] = &backLogLock{
+		holder:  1,
+		expires: time.Now().Add(-time.Second),
+		cancel:  func() {},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_, release := m.acquire("b1", 2)
+		release()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("acquire should reclaim an already-expired lock without waiting for reapExpired")
+	}
+}
+
+func TestBackLogLockManagerReapOnceCancelsExpiredHolder(t *testing.T) {
+	m := &backLogLockManager{locks: make(map[string]*backLogLock)}
+
+	canceled := false
+	m.locks["expired"] = &backLogLock{
+		holder:  1,
+		expires: time.Now().Add(-time.Second),
+		cancel:  func() { canceled = true },
+	}
+	_, liveRelease := m.acquire("live", 2)
+	defer liveRelease()
+
+	m.reapOnce()
+
+	if !canceled {
+		t.Fatalf("reapOnce should cancel an expired lock's context")
+	}
+	if _, ok := m.locks["expired"]; ok {
+		t.Fatalf("reapOnce should remove the expired lock from the map")
+	}
+	if _, ok := m.locks["live"]; !ok {
+		t.Fatalf("reapOnce should not touch a lock that hasn't expired")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"dsiem/internal/dsiem/pkg/xcorrelator"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+)
+
+const (
+	etcdBackLogPrefix  = "/dsiem/backlogs/"
+	etcdElectionPrefix = "/dsiem/election/backlog-ticker"
+	etcdDialTimeout    = 5 * time.Second
+)
+
+// errBackLogConflict is returned by putCAS when another node wrote this
+// backlog's etcd key after this node last observed it, so this node's update
+// was not applied.
+var errBackLogConflict = errors.New("backlog was modified by another node since it was last read")
+
+// etcdBackLogStore persists each backlog under its own etcd key so that any
+// node in a dsiem cluster can see backlogs replicated from its peers, and
+// uses a concurrency.Election so that only the elected leader runs the
+// timeout sweep that startBackLogTicker used to run unconditionally. Every
+// write is a compare-and-swap against the etcd mod-revision this node last
+// observed for that key (via watchBackLogs or its own previous write), so
+// two nodes racing to mutate the same backlog can't silently clobber one
+// another: the loser's update is dropped, and the winner's write reaches the
+// loser's local cache through its own watch.
+type etcdBackLogStore struct {
+	local    localBackLogStore
+	client   *clientv3.Client
+	session  *concurrency.Session
+	election *concurrency.Election
+	nodeID   string
+	isLeader bool
+
+	revMu sync.Mutex
+	// revisions tracks the etcd mod-revision this node last observed for
+	// each backlog ID, used as the CAS "expected" value in putCAS.
+	revisions map[string]int64
+}
+
+// newEtcdBackLogStore dials the given etcd endpoints. nodeID should be
+// unique per dsiem instance, e.g. its advertised host:port; it's used as
+// this node's candidate value in the leader election.
+func newEtcdBackLogStore(endpoints []string, nodeID string) (*etcdBackLogStore, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: etcdDialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &etcdBackLogStore{client: cli, nodeID: nodeID, revisions: make(map[string]int64)}, nil
+}
+
+// useEtcdBackLogStore switches the package to the distributed store. It
+// must be called before initBackLog.
+func useEtcdBackLogStore(endpoints []string, nodeID string) error {
+	s, err := newEtcdBackLogStore(endpoints, nodeID)
+	if err != nil {
+		return err
+	}
+	blStore = s
+	return nil
+}
+
+func (s *etcdBackLogStore) initBackLog() error {
+	if err := s.renewSession(); err != nil {
+		return err
+	}
+
+	go s.campaignAndSweep()
+	go s.watchBackLogs()
+	return nil
+}
+
+// campaignAndSweep blocks in the election until this node becomes leader,
+// then runs the same timeout sweep startBackLogTicker used to run
+// unconditionally, for as long as leadership is held. If the session expires
+// (e.g. this node stalls or loses connectivity), it rebuilds a fresh
+// session and election and goes back to campaigning, so this node keeps
+// competing for leadership for the life of the process instead of dropping
+// out of the election for good after one transient session loss.
+func (s *etcdBackLogStore) campaignAndSweep() {
+	for {
+		if s.session == nil {
+			if err := s.renewSession(); err != nil {
+				logWarn("etcd backlog store: failed to create etcd session: "+err.Error(), 0)
+				time.Sleep(time.Second)
+				continue
+			}
+		}
+
+		if err := s.election.Campaign(context.Background(), s.nodeID); err != nil {
+			logWarn("etcd backlog store: campaign failed: "+err.Error(), 0)
+			time.Sleep(time.Second)
+			continue
+		}
+		logInfo("etcd backlog store: "+s.nodeID+" elected leader, starting timeout sweep", 0)
+		s.isLeader = true
+		ticker = time.NewTicker(time.Second * 10)
+	leaderLoop:
+		for {
+			select {
+			case <-ticker.C:
+				sweepExpiredBackLogs()
+			case <-s.session.Done():
+				s.isLeader = false
+				logWarn("etcd backlog store: lost leadership, session closed", 0)
+				break leaderLoop
+			}
+		}
+		ticker.Stop()
+		// force renewSession on the next iteration; the closed session's
+		// election can no longer be campaigned on
+		s.session = nil
+	}
+}
+
+// renewSession dials a fresh concurrency.Session/Election pair, used both at
+// startup and whenever campaignAndSweep notices its session has closed.
+func (s *etcdBackLogStore) renewSession() error {
+	session, err := concurrency.NewSession(s.client)
+	if err != nil {
+		return err
+	}
+	s.session = session
+	s.election = concurrency.NewElection(session, etcdElectionPrefix)
+	return nil
+}
+
+// watchBackLogs streams etcd updates for every key under etcdBackLogPrefix
+// into the local cache, so backlogManager can keep matching events against
+// backlogs owned by peer nodes even though this node never wrote them.
+func (s *etcdBackLogStore) watchBackLogs() {
+	wc := s.client.Watch(context.Background(), etcdBackLogPrefix, clientv3.WithPrefix())
+	for resp := range wc {
+		for _, ev := range resp.Events {
+			switch ev.Type {
+			case clientv3.EventTypeDelete:
+				id := string(ev.Kv.Key[len(etcdBackLogPrefix):])
+				doRemoveBackLog(removalChannelMsg{ID: id})
+			default:
+				var b backLog
+				if err := json.Unmarshal(ev.Kv.Value, &b); err != nil {
+					logWarn("etcd backlog store: cannot unmarshal watch event: "+err.Error(), 0)
+					continue
+				}
+				s.replaceLocal(b, ev.Kv.ModRevision)
+			}
+		}
+	}
+}
+
+// replaceLocal upserts b into bLogs.BackLogs, used to apply watch events
+// from peer-owned backlogs without going through the local creation path.
+// modRevision is only accepted if it's newer than the revision this node
+// already has recorded for b.ID; watch delivery and this node's own putCAS
+// acks race independently, so a watch event can be the stale echo of a
+// write this node has already superseded with a later one. Applying a
+// stale echo would both roll the in-memory backlog back to old contents and
+// roll revisions[id] back, making the next local write CAS against a
+// revision etcd has already moved past and get silently rejected.
+func (s *etcdBackLogStore) replaceLocal(b backLog, modRevision int64) {
+	if !s.advanceRevision(b.ID, modRevision) {
+		return
+	}
+	bLogs.mu.Lock()
+	defer bLogs.mu.Unlock()
+	for i := range bLogs.BackLogs {
+		if bLogs.BackLogs[i].ID == b.ID {
+			bLogs.BackLogs[i] = b
+			return
+		}
+	}
+	bLogs.BackLogs = append(bLogs.BackLogs, b)
+}
+
+func (s *etcdBackLogStore) revisionOf(id string) int64 {
+	s.revMu.Lock()
+	defer s.revMu.Unlock()
+	return s.revisions[id]
+}
+
+// advanceRevision records rev as the expected CAS baseline for id only if
+// it's newer than what's already recorded, reporting whether it did. Used
+// for every revision update (not just replaceLocal's) so a late-arriving
+// stale revision, from wherever it originates, can never move the baseline
+// backwards.
+func (s *etcdBackLogStore) advanceRevision(id string, rev int64) bool {
+	s.revMu.Lock()
+	defer s.revMu.Unlock()
+	if rev <= s.revisions[id] {
+		return false
+	}
+	s.revisions[id] = rev
+	return true
+}
+
+func (s *etcdBackLogStore) forgetRevision(id string) {
+	s.revMu.Lock()
+	delete(s.revisions, id)
+	s.revMu.Unlock()
+}
+
+// putCAS writes b to etcd only if the key's mod-revision still matches
+// expectedRev, i.e. nothing has written to b.ID since this node last
+// observed it (via its own previous write, or a watch event in
+// replaceLocal). On success it records the new mod-revision so the next
+// write from this node CASes against it in turn. On a lost race it returns
+// errBackLogConflict and leaves b unwritten; the winning node's write will
+// reach this node's local cache via watchBackLogs shortly after.
+func (s *etcdBackLogStore) putCAS(b *backLog, expectedRev int64) error {
+	v, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	key := etcdBackLogPrefix + b.ID
+	resp, err := s.client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", expectedRev)).
+		Then(clientv3.OpPut(key, string(v))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return errBackLogConflict
+	}
+	s.advanceRevision(b.ID, resp.Header.Revision)
+	return nil
+}
+
+func (s *etcdBackLogStore) createNewBackLog(d *directive, e *normalizedEvent) {
+	b := doCreateNewBackLog(d, e)
+	// expectedRev 0 means "key does not exist yet", true for any backlog ID
+	// this node is minting for the first time.
+	if err := s.putCAS(&b, 0); err != nil {
+		logWarn("etcd backlog store: failed to persist new backlog "+b.ID+": "+err.Error(), e.ConnID)
+	}
+}
+
+func (s *etcdBackLogStore) removeBackLog(m removalChannelMsg) {
+	doRemoveBackLog(m)
+	s.forgetRevision(m.ID)
+	if _, err := s.client.Delete(context.Background(), etcdBackLogPrefix+m.ID); err != nil {
+		logWarn("etcd backlog store: failed to delete backlog "+m.ID+" from etcd: "+err.Error(), m.connID)
+	}
+}
+
+// processMatchedEvent mutates b locally, then CASes the result into etcd
+// against the mod-revision this node last observed for b.ID. If a peer wrote
+// a newer copy of b in the meantime, the CAS fails, this node's mutation is
+// dropped, and the peer's write reaches this node's local cache through its
+// own watchBackLogs stream - avoiding the silent overwrite that two nodes
+// unconditionally PUTting the same key would cause.
+func (s *etcdBackLogStore) processMatchedEvent(b *backLog, e *normalizedEvent, idx int, intel []xcorrelator.IntelResult) {
+	ctx, release := blLockMgr.acquire(b.ID, e.ConnID)
+	defer release()
+	expectedRev := s.revisionOf(b.ID)
+	b.processMatchedEvent(ctx, e, idx, intel)
+	if ctx.Err() != nil {
+		logWarn("etcd backlog store: lock for backlog "+b.ID+" expired mid-update, skipping persist", e.ConnID)
+		return
+	}
+	if err := s.putCAS(b, expectedRev); err != nil {
+		logWarn("etcd backlog store: failed to persist backlog "+b.ID+" after event "+
+			e.EventID+": "+err.Error(), e.ConnID)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+func newTestEtcdBackLogStore() *etcdBackLogStore {
+	return &etcdBackLogStore{revisions: make(map[string]int64)}
+}
+
+func resetBLogs() {
+	bLogs.BackLogs = nil
+}
+
+func TestAdvanceRevision(t *testing.T) {
+	s := newTestEtcdBackLogStore()
+
+	if !s.advanceRevision("b1", 5) {
+		t.Fatalf("advanceRevision should accept the first revision seen for an ID")
+	}
+	if s.revisionOf("b1") != 5 {
+		t.Fatalf("revisionOf = %d, want 5", s.revisionOf("b1"))
+	}
+
+	if s.advanceRevision("b1", 5) {
+		t.Fatalf("advanceRevision should reject a revision equal to the current one")
+	}
+	if s.advanceRevision("b1", 3) {
+		t.Fatalf("advanceRevision should reject a revision older than the current one")
+	}
+	if s.revisionOf("b1") != 5 {
+		t.Fatalf("revisionOf should be unchanged after rejected updates, got %d", s.revisionOf("b1"))
+	}
+
+	if !s.advanceRevision("b1", 9) {
+		t.Fatalf("advanceRevision should accept a strictly newer revision")
+	}
+	if s.revisionOf("b1") != 9 {
+		t.Fatalf("revisionOf = %d, want 9", s.revisionOf("b1"))
+	}
+}
+
+func TestForgetRevision(t *testing.T) {
+	s := newTestEtcdBackLogStore()
+	s.advanceRevision("b1", 5)
+	s.forgetRevision("b1")
+	if s.revisionOf("b1") != 0 {
+		t.Fatalf("revisionOf after forgetRevision = %d, want 0", s.revisionOf("b1"))
+	}
+}
+
+func TestReplaceLocalUpsertsOnNewerRevision(t *testing.T) {
+	defer resetBLogs()
+	resetBLogs()
+	s := newTestEtcdBackLogStore()
+
+	s.replaceLocal(backLog{ID: "b1", Risk: 1}, 1)
+	if len(bLogs.BackLogs) != 1 || bLogs.BackLogs[0].Risk != 1 {
+		t.Fatalf("replaceLocal should insert a not-yet-seen backlog, got %+v", bLogs.BackLogs)
+	}
+
+	s.replaceLocal(backLog{ID: "b1", Risk: 2}, 2)
+	if len(bLogs.BackLogs) != 1 || bLogs.BackLogs[0].Risk != 2 {
+		t.Fatalf("replaceLocal should update an existing backlog on a newer revision, got %+v", bLogs.BackLogs)
+	}
+	if s.revisionOf("b1") != 2 {
+		t.Fatalf("revisionOf = %d, want 2", s.revisionOf("b1"))
+	}
+}
+
+// TestReplaceLocalIgnoresStaleEcho covers the scenario the review comment
+// flagged: a watch event for a write this node already superseded arrives
+// after the fact and must not roll back either the in-memory backlog or the
+// CAS revision baseline.
+func TestReplaceLocalIgnoresStaleEcho(t *testing.T) {
+	defer resetBLogs()
+	resetBLogs()
+	s := newTestEtcdBackLogStore()
+
+	s.replaceLocal(backLog{ID: "b1", Risk: 1}, 1)
+	// This node's own later write bumps the revision past what the stale
+	// watch echo below will carry.
+	s.advanceRevision("b1", 3)
+
+	s.replaceLocal(backLog{ID: "b1", Risk: 99}, 2)
+
+	if bLogs.BackLogs[0].Risk != 1 {
+		t.Fatalf("stale echo must not roll back the in-memory backlog, got Risk=%d", bLogs.BackLogs[0].Risk)
+	}
+	if s.revisionOf("b1") != 3 {
+		t.Fatalf("stale echo must not roll back the CAS revision baseline, got %d", s.revisionOf("b1"))
+	}
+}
@@ -20,6 +20,13 @@ import (
 const (
 	intelFileGlob           = "intel_*.json"
 	maxSecondToWaitForIntel = 2
+	taxiiPollInterval       = time.Minute
+
+	// Artifact types accepted by CheckIntel and intelSource.ArtifactTypes.
+	ArtifactTypeIP     = "ip"
+	ArtifactTypeHash   = "hash"
+	ArtifactTypeURL    = "url"
+	ArtifactTypeDomain = "domain"
 )
 
 // IntelEnabled mark whether intel lookup is enabled
@@ -32,6 +39,85 @@ type intelSource struct {
 	URL         string   `json:"url"`
 	Matcher     string   `json:"matcher"`
 	ResultRegex []string `json:"result_regex"`
+
+	// ResultJSONPath holds JSONPath expressions evaluated against the
+	// response body, used when Matcher == "jsonpath".
+	ResultJSONPath []string `json:"result_jsonpath"`
+
+	// CollectionURL, APIRoot and TAXIIFilter configure polling of a
+	// STIX/TAXII 2.x collection, used when Matcher == "taxii". The
+	// collection's indicator patterns are cached in memory and matched
+	// locally instead of doing an HTTP round-trip per event.
+	CollectionURL string `json:"collection_url"`
+	APIRoot       string `json:"api_root"`
+	TAXIIFilter   string `json:"taxii_filter"`
+
+	// Auth carries credentials for providers that require authentication,
+	// resolved from an env var or file rather than being inlined in URL or
+	// this JSON so the key never ends up in an APM trace or log line.
+	Auth *intelAuth `json:"auth,omitempty"`
+
+	// RateLimit caps requests/minute to this source, enforced with a token
+	// bucket keyed by Name so a burst of events doesn't get the source
+	// disabled by a provider like VirusTotal's free tier (4 req/min). Zero
+	// means unlimited.
+	RateLimit int `json:"rate_limit"`
+
+	// PositiveCacheTTL / NegativeCacheTTL set how long a found / not-found
+	// lookup is cached, in seconds. Zero uses defaultPositiveCacheTTL /
+	// defaultNegativeCacheTTL.
+	PositiveCacheTTL int `json:"cache_positive_ttl_seconds"`
+	NegativeCacheTTL int `json:"cache_negative_ttl_seconds"`
+
+	// ArtifactTypes lists which kinds of IOC this source can look up:
+	// "ip", "hash", "url", "domain". An empty list means "ip" only, so
+	// existing ip-only intel_*.json configs keep working unchanged.
+	ArtifactTypes []string `json:"artifact_types"`
+}
+
+// supports reports whether v can be queried for the given artifact type.
+func (v intelSource) supports(artifactType string) bool {
+	if len(v.ArtifactTypes) == 0 {
+		return artifactType == ArtifactTypeIP
+	}
+	for _, t := range v.ArtifactTypes {
+		if t == artifactType {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheTTL returns how long a lookup result for v should be cached,
+// depending on whether it was found.
+func (v intelSource) cacheTTL(found bool) time.Duration {
+	if found {
+		if v.PositiveCacheTTL > 0 {
+			return time.Duration(v.PositiveCacheTTL) * time.Second
+		}
+		return defaultPositiveCacheTTL
+	}
+	if v.NegativeCacheTTL > 0 {
+		return time.Duration(v.NegativeCacheTTL) * time.Second
+	}
+	return defaultNegativeCacheTTL
+}
+
+// intelAuth configures how CheckIntel authenticates to a source.
+type intelAuth struct {
+	Type string `json:"type"` // bearer|basic|header|hmac
+
+	// KeyRef resolves the credential, e.g. "env:VT_API_KEY" or
+	// "file:/etc/dsiem/secrets/vt.key". Never inline the key itself here.
+	KeyRef string `json:"key_ref"`
+
+	// Header names the header to set for Type == "header" or "hmac"
+	// (defaults to "Authorization" for "header").
+	Header string `json:"header"`
+
+	// HMACSecretRef resolves the signing secret for Type == "hmac", using
+	// the same "env:"/"file:" scheme as KeyRef.
+	HMACSecretRef string `json:"hmac_secret_ref"`
 }
 
 // IntelResult contain results from threat intel queries
@@ -47,25 +133,95 @@ type intelSources struct {
 
 var intels intelSources
 
+// intelResultCache is populated by InitIntel; CheckIntelIP guards against it
+// being nil so a caller that skips InitIntel just never gets a cache hit.
+var intelResultCache *intelCache
+
 // CheckIntelIP lookup ip on threat intel references
 func CheckIntelIP(ip string, connID uint64) (found bool, results []IntelResult) {
+	return CheckIntel(ArtifactTypeIP, ip, connID)
+}
+
+// CheckIntelHash lookup a file hash (md5/sha1/sha256) on threat intel references
+func CheckIntelHash(hash string, connID uint64) (found bool, results []IntelResult) {
+	return CheckIntel(ArtifactTypeHash, hash, connID)
+}
+
+// CheckIntelURL lookup a URL on threat intel references
+func CheckIntelURL(u string, connID uint64) (found bool, results []IntelResult) {
+	return CheckIntel(ArtifactTypeURL, u, connID)
+}
+
+// CheckIntelDomain lookup a domain name on threat intel references
+func CheckIntelDomain(domain string, connID uint64) (found bool, results []IntelResult) {
+	return CheckIntel(ArtifactTypeDomain, domain, connID)
+}
+
+// CheckIntel looks up term, treated as an IOC of the given artifactType
+// ("ip", "hash", "url" or "domain"), against every configured intel source
+// whose ArtifactTypes includes it. The URL template variable for term is
+// "${" + artifactType + "}", e.g. "${hash}" for artifactType == "hash".
+func CheckIntel(artifactType string, term string, connID uint64) (found bool, results []IntelResult) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Warn("Panic occurred while checking intel for "+ip, connID)
+			log.Warn("Panic occurred while checking intel for "+term, connID)
 		}
 	}()
 
-	term := ip
-
 	for _, v := range intels.IntelSources {
-		url := strings.Replace(v.URL, "${ip}", ip, 1)
-		c := http.Client{Timeout: time.Second * maxSecondToWaitForIntel}
-		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if !v.supports(artifactType) {
+			continue
+		}
 
 		tx := elasticapm.DefaultTracer.StartTransaction("Threat Intel Lookup", "SIEM")
 		tx.Context.SetCustom("term", term)
 		tx.Context.SetCustom("provider", v.Name)
+
+		// TAXII sources are matched against an in-memory cache kept fresh by
+		// a background poller, so there's no per-event HTTP round-trip.
+		if v.Matcher == "taxii" {
+			f, r := matcherTAXIIIntel(v.Name, term, connID)
+			if f {
+				found = true
+				results = append(results, r...)
+			}
+			if found {
+				tx.Result = "Intel found"
+			} else {
+				tx.Result = "Intel not found"
+			}
+			tx.End()
+			continue
+		}
+
+		cacheKey := intelCacheKey(v.Name, term)
+		if intelResultCache != nil {
+			if cr, cf, hit := intelResultCache.get(cacheKey); hit {
+				tx.Context.SetCustom("cache_hit", true)
+				if cf {
+					found = true
+					results = append(results, cr...)
+					tx.Result = "Intel found (cached)"
+				} else {
+					tx.Result = "Intel not found (cached)"
+				}
+				tx.End()
+				continue
+			}
+			tx.Context.SetCustom("cache_hit", false)
+		}
+
+		if !allowIntelRequest(v) {
+			log.Warn("Rate limit exceeded for "+v.Name+" TI, skipping lookup for "+term, connID)
+			tx.Result = "rate-limited"
+			tx.End()
+			continue
+		}
+
+		url := strings.Replace(v.URL, "${"+artifactType+"}", term, 1)
 		tx.Context.SetCustom("Url", url)
+		c := http.Client{Timeout: time.Second * maxSecondToWaitForIntel}
+		req, err := http.NewRequest(http.MethodGet, url, nil)
 
 		if err != nil {
 			log.Warn("Cannot create new HTTP request for "+v.Name+" TI.", connID)
@@ -73,27 +229,46 @@ func CheckIntelIP(ip string, connID uint64) (found bool, results []IntelResult)
 			tx.End()
 			continue
 		}
+		if err := applyIntelAuth(req, v.Auth); err != nil {
+			log.Warn("Cannot apply auth for "+v.Name+" TI: "+err.Error(), connID)
+			tx.Result = "Cannot apply auth"
+			tx.End()
+			continue
+		}
 		res, err := c.Do(req)
 		if err != nil {
-			log.Warn("Failed to query "+v.Name+" TI for IP "+ip, connID)
+			log.Warn("Failed to query "+v.Name+" TI for "+term, connID)
 			tx.Result = "Failed to query " + v.Name
 			tx.End()
 			continue
 		}
 		body, readErr := ioutil.ReadAll(res.Body)
 		if readErr != nil {
-			log.Warn("Cannot read result from "+v.Name+" TI for IP "+ip, connID)
+			log.Warn("Cannot read result from "+v.Name+" TI for "+term, connID)
 			tx.Result = "Cannot read result from " + v.Name
 			tx.End()
 			continue
 		}
 
-		if v.Matcher == "regex" {
+		switch v.Matcher {
+		case "regex":
 			f, r := matcherRegexIntel(body, v.Name, term, v.ResultRegex, connID)
 			if f {
 				found = true
 				results = append(results, r...)
 			}
+			if intelResultCache != nil {
+				intelResultCache.put(cacheKey, r, f, v.cacheTTL(f))
+			}
+		case "jsonpath":
+			f, r := matcherJSONIntel(body, v.Name, term, v.ResultJSONPath, connID)
+			if f {
+				found = true
+				results = append(results, r...)
+			}
+			if intelResultCache != nil {
+				intelResultCache.put(cacheKey, r, f, v.cacheTTL(f))
+			}
 		}
 		if found {
 			tx.Result = "Intel found"
@@ -107,6 +282,8 @@ func CheckIntelIP(ip string, connID uint64) (found bool, results []IntelResult)
 
 // InitIntel initialize threat intel cross-correlation
 func InitIntel(confDir string) error {
+	intelResultCache = newIntelCache(defaultIntelCacheMaxEntries, defaultIntelCacheMaxBytes)
+
 	p := path.Join(confDir, intelFileGlob)
 	files, err := filepath.Glob(p)
 	if err != nil {
@@ -130,8 +307,12 @@ func InitIntel(confDir string) error {
 			return err
 		}
 		for j := range it.IntelSources {
-			if it.IntelSources[j].Enabled {
-				intels.IntelSources = append(intels.IntelSources, it.IntelSources[j])
+			if !it.IntelSources[j].Enabled {
+				continue
+			}
+			intels.IntelSources = append(intels.IntelSources, it.IntelSources[j])
+			if it.IntelSources[j].Matcher == "taxii" {
+				startTAXIIPoller(confDir, it.IntelSources[j])
 			}
 		}
 	}
@@ -0,0 +1,99 @@
+package xcorrelator
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resolveKeyRef reads a credential from where KeyRef/HMACSecretRef point it
+// at rather than accepting the secret inline, so it never ends up in an
+// intel_*.json file, a log line, or tx.Context.SetCustom("Url", ...).
+// Supported schemes: "env:NAME" and "file:/path/to/secret".
+func resolveKeyRef(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New("env var " + name + " is not set")
+		}
+		return v, nil
+	case strings.HasPrefix(ref, "file:"):
+		p := strings.TrimPrefix(ref, "file:")
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	default:
+		return "", errors.New("key_ref must start with \"env:\" or \"file:\", got " + ref)
+	}
+}
+
+// applyIntelAuth sets whatever headers a is configured for on req. a may be
+// nil for sources that don't require authentication.
+func applyIntelAuth(req *http.Request, a *intelAuth) error {
+	if a == nil {
+		return nil
+	}
+
+	switch a.Type {
+	case "bearer":
+		key, err := resolveKeyRef(a.KeyRef)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+
+	case "basic":
+		cred, err := resolveKeyRef(a.KeyRef)
+		if err != nil {
+			return err
+		}
+		user, pass := cred, ""
+		if i := strings.IndexByte(cred, ':'); i >= 0 {
+			user, pass = cred[:i], cred[i+1:]
+		}
+		req.SetBasicAuth(user, pass)
+
+	case "header":
+		key, err := resolveKeyRef(a.KeyRef)
+		if err != nil {
+			return err
+		}
+		header := a.Header
+		if header == "" {
+			header = "Authorization"
+		}
+		req.Header.Set(header, key)
+
+	case "hmac":
+		secret, err := resolveKeyRef(a.HMACSecretRef)
+		if err != nil {
+			return err
+		}
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(ts + req.URL.String()))
+		sig := hex.EncodeToString(mac.Sum(nil))
+
+		header := a.Header
+		if header == "" {
+			header = "X-Signature"
+		}
+		req.Header.Set(header, sig)
+		req.Header.Set("X-Signature-Timestamp", ts)
+
+	default:
+		return errors.New("unknown auth type: " + a.Type)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package xcorrelator
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveKeyRefEnv(t *testing.T) {
+	os.Setenv("DSIEM_TEST_INTEL_KEY", "s3cr3t")
+	defer os.Unsetenv("DSIEM_TEST_INTEL_KEY")
+
+	v, err := resolveKeyRef("env:DSIEM_TEST_INTEL_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "s3cr3t" {
+		t.Fatalf("got %q, want %q", v, "s3cr3t")
+	}
+}
+
+func TestResolveKeyRefEnvMissing(t *testing.T) {
+	if _, err := resolveKeyRef("env:DSIEM_TEST_INTEL_KEY_NOT_SET"); err == nil {
+		t.Fatal("expected an error for an unset env var")
+	}
+}
+
+func TestResolveKeyRefFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(p, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := resolveKeyRef("file:" + p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "file-secret" {
+		t.Fatalf("got %q, want trimmed %q", v, "file-secret")
+	}
+}
+
+func TestResolveKeyRefUnsupportedScheme(t *testing.T) {
+	if _, err := resolveKeyRef("vault:secret/intel"); err == nil {
+		t.Fatal("expected an error for an unsupported key_ref scheme")
+	}
+}
+
+func TestApplyIntelAuthNil(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err := applyIntelAuth(req, nil); err != nil {
+		t.Fatalf("expected no error for nil auth, got %v", err)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Fatal("expected no Authorization header to be set")
+	}
+}
+
+func TestApplyIntelAuthBearer(t *testing.T) {
+	os.Setenv("DSIEM_TEST_BEARER", "tok123")
+	defer os.Unsetenv("DSIEM_TEST_BEARER")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	err := applyIntelAuth(req, &intelAuth{Type: "bearer", KeyRef: "env:DSIEM_TEST_BEARER"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer tok123" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestApplyIntelAuthBasic(t *testing.T) {
+	os.Setenv("DSIEM_TEST_BASIC", "alice:hunter2")
+	defer os.Unsetenv("DSIEM_TEST_BASIC")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	err := applyIntelAuth(req, &intelAuth{Type: "basic", KeyRef: "env:DSIEM_TEST_BASIC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, pass, ok := req.BasicAuth()
+	if !ok || user != "alice" || pass != "hunter2" {
+		t.Fatalf("got user=%q pass=%q ok=%v", user, pass, ok)
+	}
+}
+
+func TestApplyIntelAuthHeaderDefaultsToAuthorization(t *testing.T) {
+	os.Setenv("DSIEM_TEST_HEADER", "abc")
+	defer os.Unsetenv("DSIEM_TEST_HEADER")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	err := applyIntelAuth(req, &intelAuth{Type: "header", KeyRef: "env:DSIEM_TEST_HEADER"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "abc" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestApplyIntelAuthHeaderCustomName(t *testing.T) {
+	os.Setenv("DSIEM_TEST_HEADER2", "xyz")
+	defer os.Unsetenv("DSIEM_TEST_HEADER2")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	err := applyIntelAuth(req, &intelAuth{Type: "header", KeyRef: "env:DSIEM_TEST_HEADER2", Header: "X-Api-Key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("X-Api-Key"); got != "xyz" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestApplyIntelAuthHMAC(t *testing.T) {
+	os.Setenv("DSIEM_TEST_HMAC", "hmac-secret")
+	defer os.Unsetenv("DSIEM_TEST_HMAC")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/lookup?ip=1.2.3.4", nil)
+	err := applyIntelAuth(req, &intelAuth{Type: "hmac", HMACSecretRef: "env:DSIEM_TEST_HMAC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("X-Signature") == "" {
+		t.Fatal("expected X-Signature header to be set")
+	}
+	if req.Header.Get("X-Signature-Timestamp") == "" {
+		t.Fatal("expected X-Signature-Timestamp header to be set")
+	}
+}
+
+func TestApplyIntelAuthUnknownType(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err := applyIntelAuth(req, &intelAuth{Type: "ntlm"}); err == nil {
+		t.Fatal("expected an error for an unsupported auth type")
+	}
+}
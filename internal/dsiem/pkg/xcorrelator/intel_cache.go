@@ -0,0 +1,116 @@
+package xcorrelator
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIntelCacheMaxEntries = 100000
+	defaultIntelCacheMaxBytes   = 50 * 1024 * 1024
+	defaultPositiveCacheTTL     = 24 * time.Hour
+	defaultNegativeCacheTTL     = 1 * time.Hour
+)
+
+// intelCacheEntry is one cached lookup: either the IntelResults a provider
+// returned, or a "not found" sentinel (found == false, results == nil).
+type intelCacheEntry struct {
+	key       string
+	results   []IntelResult
+	found     bool
+	expiresAt time.Time
+	size      int
+}
+
+// intelCache is an LRU cache of (provider, term) lookups, bounded by both
+// entry count and total byte size so a flood of distinct scanner IPs can't
+// grow it unbounded. get/put are safe for concurrent use.
+type intelCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+func newIntelCache(maxEntries, maxBytes int) *intelCache {
+	return &intelCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func intelCacheKey(provider, term string) string {
+	return provider + "\x00" + term
+}
+
+func intelCacheEntrySize(key string, results []IntelResult) int {
+	size := len(key)
+	for _, r := range results {
+		size += len(r.Provider) + len(r.Term) + len(r.Result)
+	}
+	return size
+}
+
+// get returns the cached results and found flag for key, and whether there
+// was a live (non-expired) entry at all.
+func (c *intelCache) get(key string) (results []IntelResult, found bool, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, false
+	}
+	e := el.Value.(*intelCacheEntry)
+	if time.Now().After(e.expiresAt) {
+		c.removeElement(el)
+		return nil, false, false
+	}
+	c.ll.MoveToFront(el)
+	return e.results, e.found, true
+}
+
+// put caches results (found indicates whether it's a positive or negative
+// result) under key until ttl elapses, then evicts down to maxEntries/
+// maxBytes if needed.
+func (c *intelCache) put(key string, results []IntelResult, found bool, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := intelCacheEntrySize(key, results)
+	expiresAt := time.Now().Add(ttl)
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*intelCacheEntry)
+		c.bytes += size - e.size
+		e.results, e.found, e.expiresAt, e.size = results, found, expiresAt, size
+		c.ll.MoveToFront(el)
+	} else {
+		e := &intelCacheEntry{key: key, results: results, found: found, expiresAt: expiresAt, size: size}
+		c.items[key] = c.ll.PushFront(e)
+		c.bytes += size
+	}
+	c.evict()
+}
+
+func (c *intelCache) evict() {
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		el := c.ll.Back()
+		if el == nil {
+			return
+		}
+		c.removeElement(el)
+	}
+}
+
+func (c *intelCache) removeElement(el *list.Element) {
+	e := el.Value.(*intelCacheEntry)
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
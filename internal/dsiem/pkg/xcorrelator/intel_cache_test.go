@@ -0,0 +1,108 @@
+package xcorrelator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIntelCacheGetMiss(t *testing.T) {
+	c := newIntelCache(10, 0)
+	if _, _, hit := c.get("missing"); hit {
+		t.Fatal("expected miss on empty cache")
+	}
+}
+
+func TestIntelCacheGetPutRoundTrip(t *testing.T) {
+	c := newIntelCache(10, 0)
+	results := []IntelResult{{Provider: "vt", Term: "1.2.3.4", Result: "malicious"}}
+	c.put("ip\x001.2.3.4", results, true, time.Minute)
+
+	got, found, hit := c.get("ip\x001.2.3.4")
+	if !hit {
+		t.Fatal("expected hit after put")
+	}
+	if !found {
+		t.Fatal("expected found=true for positive entry")
+	}
+	if len(got) != 1 || got[0].Term != "1.2.3.4" {
+		t.Fatalf("unexpected results: %+v", got)
+	}
+}
+
+func TestIntelCacheNegativeEntry(t *testing.T) {
+	c := newIntelCache(10, 0)
+	c.put("ip\x005.6.7.8", nil, false, time.Minute)
+
+	got, found, hit := c.get("ip\x005.6.7.8")
+	if !hit {
+		t.Fatal("expected hit for cached negative result")
+	}
+	if found {
+		t.Fatal("expected found=false for negative entry")
+	}
+	if got != nil {
+		t.Fatalf("expected nil results for negative entry, got %+v", got)
+	}
+}
+
+func TestIntelCacheExpiresAfterTTL(t *testing.T) {
+	c := newIntelCache(10, 0)
+	c.put("ip\x009.9.9.9", nil, false, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, _, hit := c.get("ip\x009.9.9.9"); hit {
+		t.Fatal("expected expired entry to be evicted on get")
+	}
+}
+
+func TestIntelCacheEvictsByMaxEntries(t *testing.T) {
+	c := newIntelCache(2, 0)
+	c.put("a", nil, false, time.Minute)
+	c.put("b", nil, false, time.Minute)
+	c.put("c", nil, false, time.Minute)
+
+	if _, _, hit := c.get("a"); hit {
+		t.Fatal("expected oldest entry to be evicted once maxEntries exceeded")
+	}
+	if _, _, hit := c.get("b"); !hit {
+		t.Fatal("expected b to survive eviction")
+	}
+	if _, _, hit := c.get("c"); !hit {
+		t.Fatal("expected c to survive eviction")
+	}
+}
+
+func TestIntelCacheEvictsByMaxBytes(t *testing.T) {
+	entry := []IntelResult{{Provider: "vt", Term: "x", Result: "long-enough-result-to-count"}}
+	size := intelCacheEntrySize("second", entry)
+
+	c := newIntelCache(0, size+1)
+	c.put("first", entry, true, time.Minute)
+	c.put("second", entry, true, time.Minute)
+
+	if _, _, hit := c.get("first"); hit {
+		t.Fatal("expected first entry to be evicted once maxBytes exceeded")
+	}
+	if _, _, hit := c.get("second"); !hit {
+		t.Fatal("expected second entry to survive")
+	}
+}
+
+func TestIntelCacheGetPromotesToFront(t *testing.T) {
+	c := newIntelCache(2, 0)
+	c.put("a", nil, false, time.Minute)
+	c.put("b", nil, false, time.Minute)
+
+	// touch "a" so "b" becomes the least recently used entry
+	if _, _, hit := c.get("a"); !hit {
+		t.Fatal("expected a to be present")
+	}
+	c.put("c", nil, false, time.Minute)
+
+	if _, _, hit := c.get("b"); hit {
+		t.Fatal("expected b to be evicted as least recently used")
+	}
+	if _, _, hit := c.get("a"); !hit {
+		t.Fatal("expected a to survive since it was touched most recently")
+	}
+}
@@ -0,0 +1,66 @@
+package xcorrelator
+
+import (
+	log "dsiem/internal/shared/pkg/logger"
+	"encoding/json"
+
+	"github.com/PaesslerAG/jsonpath"
+)
+
+// matcherJSONIntel evaluates each of paths against body as a JSON document
+// and reports a match if any expression resolves to a non-empty result,
+// e.g. "$.data.attributes.last_analysis_stats.malicious" returning a
+// non-zero count for a VirusTotal-style response.
+func matcherJSONIntel(body []byte, provider string, term string, paths []string, connID uint64) (found bool, results []IntelResult) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		log.Warn("Cannot parse JSON result from "+provider+" TI for "+term, connID)
+		return
+	}
+
+	for _, p := range paths {
+		res, err := jsonpath.Get(p, v)
+		if err != nil {
+			continue
+		}
+		if !isJSONIntelMatch(res) {
+			continue
+		}
+		found = true
+		results = append(results, IntelResult{
+			Provider: provider,
+			Term:     term,
+			Result:   jsonIntelResultString(res),
+		})
+	}
+	return
+}
+
+// isJSONIntelMatch treats a JSONPath result as a hit unless it's the zero
+// value for its type (false, 0, "", nil, or an empty slice/map).
+func isJSONIntelMatch(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	default:
+		return true
+	}
+}
+
+func jsonIntelResultString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
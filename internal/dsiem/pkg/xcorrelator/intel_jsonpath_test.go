@@ -0,0 +1,73 @@
+package xcorrelator
+
+import "testing"
+
+func TestMatcherJSONIntelMatch(t *testing.T) {
+	body := []byte(`{"data":{"attributes":{"last_analysis_stats":{"malicious":3}}}}`)
+	paths := []string{"$.data.attributes.last_analysis_stats.malicious"}
+
+	found, results := matcherJSONIntel(body, "virustotal", "1.2.3.4", paths, 0)
+	if !found {
+		t.Fatal("expected a match for a non-zero malicious count")
+	}
+	if len(results) != 1 || results[0].Provider != "virustotal" || results[0].Term != "1.2.3.4" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+}
+
+func TestMatcherJSONIntelNoMatch(t *testing.T) {
+	body := []byte(`{"data":{"attributes":{"last_analysis_stats":{"malicious":0}}}}`)
+	paths := []string{"$.data.attributes.last_analysis_stats.malicious"}
+
+	found, results := matcherJSONIntel(body, "virustotal", "1.2.3.4", paths, 0)
+	if found {
+		t.Fatalf("expected no match for a zero malicious count, got %+v", results)
+	}
+}
+
+func TestMatcherJSONIntelInvalidPathIsSkipped(t *testing.T) {
+	body := []byte(`{"data":{"malicious":1}}`)
+	paths := []string{"$.data.missing.field", "$.data"}
+
+	found, results := matcherJSONIntel(body, "provider", "term", paths, 0)
+	if !found {
+		t.Fatal("expected the valid path to still match even though the other path resolved nothing")
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected exactly one match, got %+v", results)
+	}
+}
+
+func TestMatcherJSONIntelMalformedBody(t *testing.T) {
+	found, results := matcherJSONIntel([]byte("not json"), "provider", "term", []string{"$.x"}, 0)
+	if found || results != nil {
+		t.Fatalf("expected no match for unparseable body, got found=%v results=%+v", found, results)
+	}
+}
+
+func TestIsJSONIntelMatch(t *testing.T) {
+	cases := []struct {
+		name string
+		v    interface{}
+		want bool
+	}{
+		{"nil", nil, false},
+		{"false", false, false},
+		{"true", true, true},
+		{"zero", float64(0), false},
+		{"nonzero", float64(1), true},
+		{"empty string", "", false},
+		{"nonempty string", "x", true},
+		{"empty slice", []interface{}{}, false},
+		{"nonempty slice", []interface{}{"x"}, true},
+		{"empty map", map[string]interface{}{}, false},
+		{"nonempty map", map[string]interface{}{"a": 1}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isJSONIntelMatch(c.v); got != c.want {
+				t.Errorf("isJSONIntelMatch(%#v) = %v, want %v", c.v, got, c.want)
+			}
+		})
+	}
+}
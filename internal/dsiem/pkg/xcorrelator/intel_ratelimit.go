@@ -0,0 +1,30 @@
+package xcorrelator
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+var intelLimitersMu sync.Mutex
+var intelLimiters = map[string]*rate.Limiter{}
+
+// allowIntelRequest enforces v.RateLimit (requests/minute) with a token
+// bucket keyed by v.Name, shared across every lookup for that source. A
+// RateLimit of zero means unlimited.
+func allowIntelRequest(v intelSource) bool {
+	if v.RateLimit <= 0 {
+		return true
+	}
+
+	intelLimitersMu.Lock()
+	l, ok := intelLimiters[v.Name]
+	if !ok {
+		perSecond := rate.Limit(float64(v.RateLimit) / 60)
+		l = rate.NewLimiter(perSecond, v.RateLimit)
+		intelLimiters[v.Name] = l
+	}
+	intelLimitersMu.Unlock()
+
+	return l.Allow()
+}
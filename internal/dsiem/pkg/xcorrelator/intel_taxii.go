@@ -0,0 +1,185 @@
+package xcorrelator
+
+import (
+	log "dsiem/internal/shared/pkg/logger"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// taxiiIndicatorPattern extracts the address out of a STIX 2.1 comparison
+// pattern like "[ipv4-addr:value = '1.2.3.4']" or an OR'd combination of
+// ipv4-addr/ipv6-addr comparisons.
+var taxiiIndicatorPattern = regexp.MustCompile(`ipv[46]-addr:value\s*=\s*'([^']+)'`)
+
+// taxiiCollectionCache holds the IP addresses extracted from one TAXII
+// collection's indicator patterns, refreshed by startTAXIIPoller.
+type taxiiCollectionCache struct {
+	mu  sync.RWMutex
+	ips map[string]bool
+}
+
+var taxiiCachesMu sync.Mutex
+var taxiiCaches = map[string]*taxiiCollectionCache{}
+
+// taxiiPollState is the added_after bookkeeping persisted next to the intel
+// config, so a restart resumes polling from where it left off instead of
+// re-fetching the whole collection.
+type taxiiPollState struct {
+	AddedAfter string `json:"added_after"`
+}
+
+type taxiiBundle struct {
+	Objects []taxiiObject `json:"objects"`
+}
+
+type taxiiObject struct {
+	Type     string `json:"type"`
+	Pattern  string `json:"pattern"`
+	Created  string `json:"created"`
+	Modified string `json:"modified"`
+}
+
+func taxiiStateFile(confDir, name string) string {
+	return path.Join(confDir, "."+name+".taxii_state.json")
+}
+
+func loadTAXIIState(confDir, name string) taxiiPollState {
+	var s taxiiPollState
+	b, err := ioutil.ReadFile(taxiiStateFile(confDir, name))
+	if err != nil {
+		return s
+	}
+	_ = json.Unmarshal(b, &s)
+	return s
+}
+
+func saveTAXIIState(confDir, name string, s taxiiPollState) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(taxiiStateFile(confDir, name), b, 0600); err != nil {
+		log.Warn("Cannot persist TAXII poll state for "+name+": "+err.Error(), 0)
+	}
+}
+
+// startTAXIIPoller periodically fetches new objects from v's collection
+// and merges any indicator patterns found into the in-memory cache that
+// matcherTAXIIIntel reads, so CheckIntelIP never does an HTTP round-trip
+// per event for TAXII sources.
+func startTAXIIPoller(confDir string, v intelSource) {
+	taxiiCachesMu.Lock()
+	if _, ok := taxiiCaches[v.Name]; !ok {
+		taxiiCaches[v.Name] = &taxiiCollectionCache{ips: make(map[string]bool)}
+	}
+	taxiiCachesMu.Unlock()
+
+	state := loadTAXIIState(confDir, v.Name)
+
+	go func() {
+		for {
+			next, err := pollTAXIICollection(v, state.AddedAfter)
+			if err != nil {
+				log.Warn("TAXII poll failed for "+v.Name+": "+err.Error(), 0)
+			} else if next != "" {
+				state.AddedAfter = next
+				saveTAXIIState(confDir, v.Name, state)
+			}
+			time.Sleep(taxiiPollInterval)
+		}
+	}()
+}
+
+// pollTAXIICollection fetches objects added after addedAfter from v's
+// collection, merges any indicator IPs into the in-memory cache, and
+// returns the newest object timestamp seen so the caller can persist it.
+func pollTAXIICollection(v intelSource, addedAfter string) (newestAddedAfter string, err error) {
+	reqURL := strings.TrimRight(v.CollectionURL, "/") + "/objects/"
+	q := url.Values{}
+	if addedAfter != "" {
+		q.Set("added_after", addedAfter)
+	}
+	if v.TAXIIFilter != "" {
+		q.Set("match[type]", v.TAXIIFilter)
+	} else {
+		q.Set("match[type]", "indicator")
+	}
+	reqURL += "?" + q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/taxii+json;version=2.1")
+	if v.APIRoot != "" {
+		req.Header.Set("X-TAXII-API-Root", v.APIRoot)
+	}
+
+	c := http.Client{Timeout: time.Second * maxSecondToWaitForIntel * 5}
+	res, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var bundle taxiiBundle
+	if err := json.Unmarshal(body, &bundle); err != nil {
+		return "", err
+	}
+
+	taxiiCachesMu.Lock()
+	cache := taxiiCaches[v.Name]
+	taxiiCachesMu.Unlock()
+
+	cache.mu.Lock()
+	for _, o := range bundle.Objects {
+		if o.Type != "indicator" {
+			continue
+		}
+		for _, m := range taxiiIndicatorPattern.FindAllStringSubmatch(o.Pattern, -1) {
+			cache.ips[m[1]] = true
+		}
+		ts := o.Modified
+		if ts == "" {
+			ts = o.Created
+		}
+		if ts > newestAddedAfter {
+			newestAddedAfter = ts
+		}
+	}
+	cache.mu.Unlock()
+
+	return newestAddedAfter, nil
+}
+
+// matcherTAXIIIntel checks term against the indicator IPs cached for the
+// named TAXII source by startTAXIIPoller.
+func matcherTAXIIIntel(provider string, term string, connID uint64) (found bool, results []IntelResult) {
+	taxiiCachesMu.Lock()
+	cache, ok := taxiiCaches[provider]
+	taxiiCachesMu.Unlock()
+	if !ok {
+		return
+	}
+
+	cache.mu.RLock()
+	found = cache.ips[term]
+	cache.mu.RUnlock()
+
+	if found {
+		results = append(results, IntelResult{Provider: provider, Term: term, Result: "matched TAXII indicator"})
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package xcorrelator
+
+import "testing"
+
+func TestTaxiiIndicatorPatternExtractsIPv4(t *testing.T) {
+	m := taxiiIndicatorPattern.FindAllStringSubmatch(`[ipv4-addr:value = '203.0.113.7']`, -1)
+	if len(m) != 1 || m[0][1] != "203.0.113.7" {
+		t.Fatalf("unexpected matches: %+v", m)
+	}
+}
+
+func TestTaxiiIndicatorPatternExtractsOredComparisons(t *testing.T) {
+	pattern := `[ipv4-addr:value = '203.0.113.7' OR ipv6-addr:value = '2001:db8::1']`
+	m := taxiiIndicatorPattern.FindAllStringSubmatch(pattern, -1)
+	if len(m) != 2 {
+		t.Fatalf("expected 2 matches, got %+v", m)
+	}
+	if m[0][1] != "203.0.113.7" || m[1][1] != "2001:db8::1" {
+		t.Fatalf("unexpected matches: %+v", m)
+	}
+}
+
+func TestTaxiiIndicatorPatternNoMatch(t *testing.T) {
+	m := taxiiIndicatorPattern.FindAllStringSubmatch(`[file:hashes.'SHA-256' = 'deadbeef']`, -1)
+	if len(m) != 0 {
+		t.Fatalf("expected no matches for a non-IP pattern, got %+v", m)
+	}
+}
+
+func TestMatcherTAXIIIntelMatch(t *testing.T) {
+	taxiiCachesMu.Lock()
+	taxiiCaches["otx"] = &taxiiCollectionCache{ips: map[string]bool{"198.51.100.9": true}}
+	taxiiCachesMu.Unlock()
+
+	found, results := matcherTAXIIIntel("otx", "198.51.100.9", 0)
+	if !found {
+		t.Fatal("expected a match for a cached indicator")
+	}
+	if len(results) != 1 || results[0].Provider != "otx" || results[0].Term != "198.51.100.9" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+}
+
+func TestMatcherTAXIIIntelNoMatch(t *testing.T) {
+	taxiiCachesMu.Lock()
+	taxiiCaches["otx-empty"] = &taxiiCollectionCache{ips: map[string]bool{}}
+	taxiiCachesMu.Unlock()
+
+	found, results := matcherTAXIIIntel("otx-empty", "198.51.100.9", 0)
+	if found || results != nil {
+		t.Fatalf("expected no match, got found=%v results=%+v", found, results)
+	}
+}
+
+func TestMatcherTAXIIIntelUnknownSource(t *testing.T) {
+	found, results := matcherTAXIIIntel("never-configured", "198.51.100.9", 0)
+	if found || results != nil {
+		t.Fatalf("expected no match for an unconfigured source, got found=%v results=%+v", found, results)
+	}
+}